@@ -0,0 +1,104 @@
+package dkim
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestSignVerifySimpleCanonicalization(t *testing.T) {
+	d, resolver := newTestDKIM(t)
+	d.conf[CanonicalizationKey] = "simple/simple"
+
+	signed, err := d.Sign([]byte(verifySampleEML))
+	if err != nil {
+		t.Fatal("error signing", err)
+	}
+
+	result, err := Verify(signed, resolver)
+	if err != nil {
+		t.Fatal("error verifying", err)
+	}
+	if result.Code != SUCCESS {
+		t.Fatal("expected SUCCESS, got", result.Code, result.Reason)
+	}
+}
+
+func TestSignVerifyMixedCanonicalization(t *testing.T) {
+	d, resolver := newTestDKIM(t)
+	d.conf[CanonicalizationKey] = "simple/relaxed"
+
+	signed, err := d.Sign([]byte(verifySampleEML))
+	if err != nil {
+		t.Fatal("error signing", err)
+	}
+
+	result, err := Verify(signed, resolver)
+	if err != nil {
+		t.Fatal("error verifying", err)
+	}
+	if result.Code != SUCCESS {
+		t.Fatal("expected SUCCESS, got", result.Code, result.Reason)
+	}
+}
+
+// TestCanonicalizeBodySimplePreservesTrailingWSP makes sure "simple" body
+// canonicalization only strips trailing empty lines and leaves the
+// body's last content line, trailing whitespace included, untouched per
+// RFC 6376 section 3.4.3.
+func TestCanonicalizeBodySimplePreservesTrailingWSP(t *testing.T) {
+	got := string(canonicalizeBody([]byte("Hello World   \r\n"), false))
+	want := "Hello World   \r\n"
+	if got != want {
+		t.Fatalf("canonicalizeBody(simple) = %q, want %q", got, want)
+	}
+}
+
+// TestSignSimpleHashesRawHeaderBytes makes sure "simple" header
+// canonicalization hashes the header fields' actual wire bytes - casing,
+// folding and all - rather than mail.Header's re-cased, unfolded copy of
+// them, per RFC 6376 section 3.4.1.
+func TestSignSimpleHashesRawHeaderBytes(t *testing.T) {
+	d, resolver := newTestDKIM(t)
+	d.conf[CanonicalizationKey] = "simple/simple"
+
+	eml := "Subject: Hello\r\n World\r\n" +
+		"from: \"Fook\" <fook@haiyiyun.com>\r\n" +
+		"\r\n" +
+		"This is an email\r\n"
+
+	header, _ := splitHeaderAndBody([]byte(eml))
+	msg, err := readEML([]byte(eml))
+	if err != nil {
+		t.Fatal("error reading message", err)
+	}
+
+	_, block := d.signableHeaderBlock(msg, header)
+	if !strings.Contains(block, "Subject: Hello\r\n World") {
+		t.Fatalf("expected the folded Subject header to survive verbatim in the hashed block, got %q", block)
+	}
+	if !strings.Contains(block, "from: \"Fook\" <fook@haiyiyun.com>") {
+		t.Fatalf("expected the lower-case from: field name to survive verbatim in the hashed block, got %q", block)
+	}
+
+	signed, err := d.Sign([]byte(eml))
+	if err != nil {
+		t.Fatal("error signing", err)
+	}
+
+	result, err := Verify(signed, resolver)
+	if err != nil {
+		t.Fatal("error verifying", err)
+	}
+	if result.Code != SUCCESS {
+		t.Fatal("expected SUCCESS, got", result.Code, result.Reason)
+	}
+}
+
+func TestConfValidateRejectsBadCanonicalization(t *testing.T) {
+	conf := NewConf("haiyiyun.com", "default")
+	conf[CanonicalizationKey] = "simple/bogus"
+
+	if err := conf.Validate(); err == nil {
+		t.Fatal("expected an error for an invalid c= tag")
+	}
+}
@@ -0,0 +1,215 @@
+package dkim
+
+import (
+	"crypto"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// DKIM-Signature tag names, as defined by RFC 6376 section 3.2.
+const (
+	VersionKey          = "v"
+	AlgorithmKey        = "a"
+	SignatureDataKey    = "b"
+	BodyHashKey         = "bh"
+	CanonicalizationKey = "c"
+	DomainKey           = "d"
+	FieldsKey           = "h"
+	AUIDKey             = "i"
+	BodyLengthKey       = "l"
+	QueryMethodKey      = "q"
+	SelectorKey         = "s"
+	TimestampKey        = "t"
+	ExpirationKey       = "x"
+	CopiedFieldsKey     = "z"
+)
+
+// confKeyOrder is the order in which tags are emitted in a DKIM-Signature
+// header, matching the common convention used by other implementations.
+var confKeyOrder = []string{
+	VersionKey,
+	AlgorithmKey,
+	CanonicalizationKey,
+	DomainKey,
+	SelectorKey,
+	AUIDKey,
+	QueryMethodKey,
+	FieldsKey,
+	TimestampKey,
+	ExpirationKey,
+	BodyLengthKey,
+	CopiedFieldsKey,
+	BodyHashKey,
+	SignatureDataKey,
+}
+
+// Conf holds the tag=value pairs of a DKIM-Signature header.
+type Conf map[string]string
+
+// Clone returns a copy of c, independent of the original.
+func (c Conf) Clone() Conf {
+	clone := make(Conf, len(c))
+	for k, v := range c {
+		clone[k] = v
+	}
+
+	return clone
+}
+
+// NewConf returns a Conf pre-populated with the defaults this package signs
+// with: RSA-SHA256, relaxed/relaxed canonicalization and dns/txt as the
+// query method.
+func NewConf(domain, selector string) Conf {
+	return Conf{
+		VersionKey:          "1",
+		AlgorithmKey:        "rsa-sha256",
+		CanonicalizationKey: "relaxed/relaxed",
+		QueryMethodKey:      "dns/txt",
+		DomainKey:           domain,
+		SelectorKey:         selector,
+	}
+}
+
+// ParseConf parses the tag=value list of a DKIM-Signature header value,
+// as emitted by Conf.String, back into a Conf.
+func ParseConf(raw string) (Conf, error) {
+	tags, err := parseTagList(raw)
+	if err != nil {
+		return nil, err
+	}
+
+	return Conf(tags), nil
+}
+
+func (c Conf) Validate() error {
+	if c[DomainKey] == "" {
+		return errors.New("dkim: missing domain (d=)")
+	}
+	if c[SelectorKey] == "" {
+		return errors.New("dkim: missing selector (s=)")
+	}
+
+	switch c[AlgorithmKey] {
+	case "", "rsa-sha256", "rsa-sha1", "ed25519-sha256":
+	default:
+		return fmt.Errorf("dkim: unsupported algorithm %q", c[AlgorithmKey])
+	}
+
+	if canon := c[CanonicalizationKey]; canon != "" {
+		parts := strings.SplitN(canon, "/", 2)
+		for _, side := range parts {
+			if side != "simple" && side != "relaxed" {
+				return fmt.Errorf("dkim: unsupported canonicalization %q", canon)
+			}
+		}
+	}
+
+	return nil
+}
+
+// Hash returns the digest algorithm to use for both the body hash and the
+// header signature, derived from the a= tag.
+func (c Conf) Hash() crypto.Hash {
+	if strings.HasSuffix(c[AlgorithmKey], "sha1") {
+		return crypto.SHA1
+	}
+
+	return crypto.SHA256
+}
+
+// RelaxedHeader reports whether the header canonicalization side of c=
+// is "relaxed".
+func (c Conf) RelaxedHeader() bool {
+	header, _ := c.canonicalization()
+	return header == "relaxed"
+}
+
+// RelaxedBody reports whether the body canonicalization side of c= is
+// "relaxed".
+func (c Conf) RelaxedBody() bool {
+	_, body := c.canonicalization()
+	return body == "relaxed"
+}
+
+func (c Conf) canonicalization() (header, body string) {
+	header, body = "simple", "simple"
+
+	canon := c[CanonicalizationKey]
+	if canon == "" {
+		return
+	}
+
+	parts := strings.SplitN(canon, "/", 2)
+	header = parts[0]
+	if len(parts) == 2 {
+		body = parts[1]
+	} else {
+		body = header
+	}
+
+	return
+}
+
+func (c Conf) String() string {
+	parts := make([]string, 0, len(confKeyOrder))
+	for _, k := range confKeyOrder {
+		if v, ok := c[k]; ok {
+			parts = append(parts, k+"="+v)
+		}
+	}
+
+	return " " + strings.Join(parts, "; ")
+}
+
+// MaxHeaderLineLength is the column at which Header folds the emitted
+// DKIM-Signature header, per the recommendation in RFC 6376 section 3.5.
+const MaxHeaderLineLength = 78
+
+// Header renders the DKIM-Signature header for c, folded into lines of at
+// most MaxHeaderLineLength columns using "CRLF SP" as folding whitespace.
+// Folds fall between tags where possible; an overlong tag (typically the
+// base64 b= value) is hard-folded across as many lines as it takes, since
+// FWS is insignificant once unfolded and so may be inserted anywhere
+// within it.
+func (c Conf) Header() string {
+	lines := []string{SignatureHeaderKey + ":"}
+
+	for i, k := range confKeyOrder {
+		v, ok := c[k]
+		if !ok {
+			continue
+		}
+
+		tag := k + "=" + v
+		if i < len(confKeyOrder)-1 {
+			tag += ";"
+		}
+
+		for len(tag) > 0 {
+			last := lines[len(lines)-1]
+			room := MaxHeaderLineLength - len(last) - 1
+
+			switch {
+			case room >= len(tag):
+				lines[len(lines)-1] = last + " " + tag
+				tag = ""
+			case room >= MaxHeaderLineLength/2 || last == SignatureHeaderKey+":":
+				// Enough room to make a dent, or this is the first tag on
+				// the line: hard-fold the tag across lines.
+				if room < 1 {
+					room = 1
+				}
+				lines[len(lines)-1] = last + " " + tag[:room]
+				tag = tag[room:]
+				lines = append(lines, "")
+			default:
+				// Not worth hard-folding into the little space left; move
+				// the whole tag to a fresh line instead.
+				lines = append(lines, "")
+			}
+		}
+	}
+
+	return strings.Join(lines, "\r\n")
+}
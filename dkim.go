@@ -2,15 +2,19 @@ package dkim
 
 import (
 	"bytes"
+	"crypto"
+	"crypto/ed25519"
 	"crypto/rand"
-	"crypto/rsa"
 	"crypto/x509"
 	"encoding/base64"
 	"encoding/pem"
 	"errors"
+	"fmt"
 	"net/mail"
 	"regexp"
+	"strconv"
 	"strings"
+	"time"
 )
 
 const (
@@ -31,9 +35,32 @@ var StdSignableHeaders = []string{
 var headerRelaxRx = regexp.MustCompile(`\s+`)
 
 type DKIM struct {
-	signableHeaders []string
-	conf            Conf
-	privateKey      *rsa.PrivateKey
+	signableHeaders   []string
+	conf              Conf
+	signer            crypto.Signer
+	resolver          Resolver
+	bodyLength        int64
+	includeTimestamp  bool
+	signatureExpireIn time.Duration
+}
+
+// SetBodyLength makes Sign emit an l= tag and truncate the canonicalized
+// body to n octets before hashing it, instead of hashing the whole body.
+func (d *DKIM) SetBodyLength(n int64) {
+	d.bodyLength = n
+}
+
+// SetTimestamp makes Sign stamp the signature with a t= tag set to the
+// current time.
+func (d *DKIM) SetTimestamp(enabled bool) {
+	d.includeTimestamp = enabled
+}
+
+// SetSignatureExpireIn makes Sign emit an x= tag expiring exp after the
+// signature's t= timestamp (which is added automatically if not already
+// enabled via SetTimestamp).
+func (d *DKIM) SetSignatureExpireIn(exp time.Duration) {
+	d.signatureExpireIn = exp
 }
 
 func New(conf Conf, keyPEM []byte) (d *DKIM, err error) {
@@ -44,18 +71,61 @@ func New(conf Conf, keyPEM []byte) (d *DKIM, err error) {
 	if len(keyPEM) == 0 {
 		return nil, errors.New("invalid key PEM data")
 	}
+
+	signer, err := parsePrivateKey(keyPEM)
+	if err != nil {
+		return nil, err
+	}
+
 	dkim := &DKIM{
 		signableHeaders: StdSignableHeaders,
 		conf:            conf,
+		signer:          signer,
+		resolver:        dnsResolver{},
 	}
+	dkim.conf[AlgorithmKey] = dkim.algorithm()
+
+	return dkim, nil
+}
+
+// parsePrivateKey decodes a PEM-encoded private key, sniffing the block
+// type to tell an RSA key (PKCS#1, "RSA PRIVATE KEY") from the PKCS#8
+// container ("PRIVATE KEY") used for Ed25519 keys.
+func parsePrivateKey(keyPEM []byte) (crypto.Signer, error) {
 	der, _ := pem.Decode(keyPEM)
-	key, err := x509.ParsePKCS1PrivateKey(der.Bytes)
+	if der == nil {
+		return nil, errors.New("invalid key PEM data")
+	}
+
+	if der.Type == "RSA PRIVATE KEY" {
+		return x509.ParsePKCS1PrivateKey(der.Bytes)
+	}
+
+	key, err := x509.ParsePKCS8PrivateKey(der.Bytes)
 	if err != nil {
 		return nil, err
 	}
-	dkim.privateKey = key
 
-	return dkim, nil
+	signer, ok := key.(crypto.Signer)
+	if !ok {
+		return nil, fmt.Errorf("dkim: unsupported private key type %T", key)
+	}
+
+	return signer, nil
+}
+
+// algorithm returns the DKIM a= tag value for d's signer and configured
+// hash. Ed25519 signatures are fixed to SHA-256 by RFC 8463.
+func (d *DKIM) algorithm() string {
+	if _, ok := d.signer.Public().(ed25519.PublicKey); ok {
+		return "ed25519-sha256"
+	}
+
+	if d.conf.Hash() == crypto.SHA1 {
+		return "rsa-sha1"
+	}
+
+	return "rsa-sha256"
 }
 
 func (d *DKIM) canonicalBody(msg *mail.Message) []byte {
@@ -67,9 +137,20 @@ func (d *DKIM) canonicalBody(msg *mail.Message) []byte {
 	if msg.Body != nil {
 		buf.ReadFrom(msg.Body)
 	}
-	body := buf.Bytes()
 
-	if d.conf.RelaxedBody() {
+	body := canonicalizeBody(buf.Bytes(), d.conf.RelaxedBody())
+	if d.bodyLength > 0 && int64(len(body)) > d.bodyLength {
+		body = body[:d.bodyLength]
+	}
+
+	return body
+}
+
+// canonicalizeBody applies the body canonicalization algorithm (RFC 6376
+// section 3.4.3/3.4.4) to body, so it can be shared between signing and
+// verification.
+func canonicalizeBody(body []byte, relaxed bool) []byte {
+	if relaxed {
 		if len(body) == 0 {
 			return nil
 		}
@@ -88,8 +169,15 @@ func (d *DKIM) canonicalBody(msg *mail.Message) []byte {
 		}
 	}
 
-	// Ignore all empty lines at the end of the message body
-	rx3 := regexp.MustCompile(`[ \r\n]*\z`)
+	// Ignore all empty lines at the end of the message body. Only full
+	// line terminators are stripped here, not trailing WSP on the body's
+	// last content line: in "simple" mode that line must survive
+	// untouched, and in "relaxed" mode its trailing WSP has already been
+	// removed above, or is removed here if the line is unterminated.
+	if relaxed {
+		body = bytes.TrimRight(body, " \t")
+	}
+	rx3 := regexp.MustCompile(`(?:\r\n|\n)*\z`)
 	body = rx3.ReplaceAll(body, []byte(""))
 
 	return append(body, '\r', '\n')
@@ -103,7 +191,28 @@ func (d *DKIM) canonicalBodyHash(msg *mail.Message) []byte {
 	return digest.Sum(nil)
 }
 
-func (d *DKIM) signableHeaderBlock(msg *mail.Message) string {
+func (d *DKIM) signableHeaderBlock(msg *mail.Message, rawHeader []byte) (Conf, string) {
+	return d.signableHeaderBlockWithBodyHash(msg, rawHeader, d.canonicalBodyHash(msg))
+}
+
+// signableHeaderBlockWithBodyHash builds the canonicalized header block to
+// be hashed and signed, using bodyHash as the already-computed bh= value
+// instead of reading msg.Body. This lets NewSigner feed in a body hash
+// computed incrementally by a streaming canonicalizer, without buffering
+// the whole body in memory. rawHeader is the original header block byte
+// for byte (as returned by splitHeaderAndBody); it's what "simple" header
+// canonicalization hashes, since msg.Header has already re-cased field
+// names and unfolded continuation lines by the time it reaches here.
+//
+// It returns the per-message Conf built up along the way (a copy of
+// d.conf carrying this message's t=/x=/h=/bh=, with b= held empty) rather
+// than writing those tags into d.conf directly, since a single *DKIM is
+// meant to be reused to sign many messages - including concurrently, from
+// multiple goroutines, per NewSigner's milter/proxy use case - and d.conf
+// is shared across all of them.
+func (d *DKIM) signableHeaderBlockWithBodyHash(msg *mail.Message, rawHeader []byte, bodyHash []byte) (Conf, string) {
+	conf := d.conf.Clone()
+
 	signableHeaderList := make(mail.Header)
 	signableHeaderKeys := make([]string, 0)
 
@@ -114,35 +223,89 @@ func (d *DKIM) signableHeaderBlock(msg *mail.Message) string {
 		}
 	}
 
-	d.conf[BodyHashKey] = base64.StdEncoding.EncodeToString(d.canonicalBodyHash(msg))
-	d.conf[FieldsKey] = strings.Join(signableHeaderKeys, ":")
+	rawFields := parseRawHeaderFields(rawHeader)
+
+	if d.bodyLength > 0 {
+		conf[BodyLengthKey] = strconv.FormatInt(d.bodyLength, 10)
+	}
+	if d.includeTimestamp || d.signatureExpireIn > 0 {
+		if _, ok := conf[TimestampKey]; !ok {
+			conf[TimestampKey] = strconv.FormatInt(time.Now().Unix(), 10)
+		}
+	}
+	if d.signatureExpireIn > 0 {
+		t, _ := strconv.ParseInt(conf[TimestampKey], 10, 64)
+		conf[ExpirationKey] = strconv.FormatInt(t+int64(d.signatureExpireIn.Seconds()), 10)
+	}
+
+	conf[BodyHashKey] = base64.StdEncoding.EncodeToString(bodyHash)
+	conf[FieldsKey] = strings.Join(signableHeaderKeys, ":")
+	// The b= tag must be present (with an empty value) while computing the
+	// signature over itself; see RFC 6376 section 3.7.
+	conf[SignatureDataKey] = ""
 
-	signableHeaderList[SignatureHeaderKey] = []string{d.conf.String()}
+	signableHeaderList[SignatureHeaderKey] = []string{conf.String()}
 	signableHeaderKeys = append(signableHeaderKeys, SignatureHeaderKey)
 
-	relax := d.conf.RelaxedHeader()
+	relax := conf.RelaxedHeader()
 	canonical := make([]string, 0, len(signableHeaderKeys))
 	for _, k := range signableHeaderKeys {
-		v := signableHeaderList[k][0]
-		if relax {
-			v = headerRelaxRx.ReplaceAllString(v, " ")
-			k = strings.ToLower(k)
+		// The DKIM-Signature header being built here doesn't exist in
+		// rawHeader yet, so it has no wire bytes of its own to canonicalize.
+		if !relax && k != SignatureHeaderKey {
+			if raw := rawFieldValues(rawFields, k); len(raw) != 0 {
+				canonical = append(canonical, raw[0])
+				continue
+			}
 		}
-		canonical = append(canonical, k+":"+strings.TrimSpace(v))
+		canonical = append(canonical, canonicalizeHeaderField(k, signableHeaderList[k][0], relax))
 	}
 	// According to RFC6376 http://tools.ietf.org/html/rfc6376#section-3.7
 	// the DKIM header must be inserted without a trailing <CRLF>.
 	// That's why we have to trim the space from the canonical header.
-	return strings.TrimSpace(strings.Join(canonical, "\r\n") + "\r\n")
+	return conf, strings.TrimSpace(strings.Join(canonical, "\r\n") + "\r\n")
+}
+
+// canonicalizeHeaderField canonicalizes a single header field, so it can
+// be shared between signing and verification. "relaxed" unfolds the
+// value's whitespace to single SPs, trims it and lowercases the field
+// name (RFC 6376 section 3.4.2); "simple" passes the field through as
+// received, name casing and all (section 3.4.1).
+func canonicalizeHeaderField(k, v string, relaxed bool) string {
+	if !relaxed {
+		return k + ": " + v
+	}
+
+	v = headerRelaxRx.ReplaceAllString(v, " ")
+
+	return strings.ToLower(k) + ":" + strings.TrimSpace(v)
+}
+
+func (d *DKIM) signature(msg *mail.Message, rawHeader []byte) (Conf, string, error) {
+	conf, block := d.signableHeaderBlock(msg, rawHeader)
+	sig, err := d.signBlock(conf, block)
+	return conf, sig, err
 }
 
-func (d *DKIM) signature(msg *mail.Message) (string, error) {
-	block := d.signableHeaderBlock(msg)
-	hash := d.conf.Hash()
+// signBlock signs the canonicalized header block, returning the base64
+// b= value. It is shared by signature and Signer.Close, which arrive at
+// the block via different paths (a fully buffered *mail.Message versus an
+// incrementally hashed body).
+func (d *DKIM) signBlock(conf Conf, block string) (string, error) {
+	hash := conf.Hash()
 	digest := hash.New()
 	digest.Write([]byte(block))
+	sum := digest.Sum(nil)
+
+	// Ed25519 signs its input directly rather than a pre-computed digest,
+	// so crypto.Hash(0) tells it sum is the message, not a digest to
+	// re-hash under some other algorithm.
+	var opts crypto.SignerOpts = hash
+	if _, ok := d.signer.Public().(ed25519.PublicKey); ok {
+		opts = crypto.Hash(0)
+	}
 
-	sig, err := rsa.SignPKCS1v15(rand.Reader, d.privateKey, hash, digest.Sum(nil))
+	sig, err := d.signer.Sign(rand.Reader, sum, opts)
 	if err != nil {
 		return "", err
 	}
@@ -163,23 +326,23 @@ func (d *DKIM) Sign(eml []byte) (signed []byte, err error) {
 	if err != nil {
 		return
 	}
-	sig, err := d.signature(msg)
+
+	// Preserve the original header block byte-for-byte instead of
+	// round-tripping through mail.Header, which would reorder headers and
+	// silently drop repeated ones (e.g. multiple Received: headers). It
+	// also feeds "simple" header canonicalization, which must hash these
+	// exact wire bytes rather than msg.Header's re-cased, unfolded copy.
+	headerBlock, _ := splitHeaderAndBody(eml)
+
+	conf, sig, err := d.signature(msg, headerBlock)
 	if err != nil {
 		return
 	}
-	d.conf[SignatureDataKey] = sig
-
-	// Append the signature header. Keep in mind these are raw values,
-	// so we add a <SP> character before the key-value list
-	/* msg.Header[SignatureHeaderKey] = []string{d.conf.String()} */
+	conf[SignatureDataKey] = sig
 
 	buf := new(bytes.Buffer)
-	for k, _ := range msg.Header {
-		s := k + ": " + msg.Header.Get(k) + "\r\n"
-		buf.Write([]byte(s))
-	}
-
-	buf.Write([]byte(SignatureHeaderKey + ":" + d.conf.String()))
+	buf.Write(headerBlock)
+	buf.WriteString(conf.Header())
 	buf.Write([]byte("\r\n\r\n"))
 	buf.Write(bodyb)
 
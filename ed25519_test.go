@@ -0,0 +1,47 @@
+package dkim
+
+import (
+	"crypto/ed25519"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"testing"
+)
+
+func TestSignVerifyEd25519(t *testing.T) {
+	pub, priv, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal("error generating key", err)
+	}
+
+	pkcs8, err := x509.MarshalPKCS8PrivateKey(priv)
+	if err != nil {
+		t.Fatal("error marshaling private key", err)
+	}
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: pkcs8})
+
+	conf := NewConf("haiyiyun.com", "default")
+	d, err := New(conf, keyPEM)
+	if err != nil {
+		t.Fatal("error creating signer", err)
+	}
+	if got := conf[AlgorithmKey]; got != "ed25519-sha256" {
+		t.Fatal("expected a=ed25519-sha256, got", got)
+	}
+
+	record := "v=DKIM1; k=ed25519; p=" + base64.StdEncoding.EncodeToString(pub)
+	resolver := fakeResolver{"default._domainkey.haiyiyun.com": []string{record}}
+
+	signed, err := d.Sign([]byte(verifySampleEML))
+	if err != nil {
+		t.Fatal("error signing", err)
+	}
+
+	result, err := Verify(signed, resolver)
+	if err != nil {
+		t.Fatal("error verifying", err)
+	}
+	if result.Code != SUCCESS {
+		t.Fatal("expected SUCCESS, got", result.Code, result.Reason)
+	}
+}
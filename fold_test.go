@@ -0,0 +1,56 @@
+package dkim
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestConfHeaderFolds(t *testing.T) {
+	conf := NewConf("haiyiyun.com", "default")
+	conf[FieldsKey] = "From:To:Subject"
+	conf[BodyHashKey] = "deadbeef=="
+	conf[SignatureDataKey] = strings.Repeat("A", 300)
+
+	header := conf.Header()
+	for _, line := range strings.Split(header, "\r\n") {
+		if len(line) > MaxHeaderLineLength {
+			t.Fatalf("line exceeds %d columns (%d): %q", MaxHeaderLineLength, len(line), line)
+		}
+	}
+	if !strings.HasPrefix(header, SignatureHeaderKey+":") {
+		t.Fatal("expected header to start with the DKIM-Signature key", header)
+	}
+
+	folded, err := ParseConf(strings.TrimPrefix(header, SignatureHeaderKey+":"))
+	if err != nil {
+		t.Fatal("error parsing folded header back", err)
+	}
+	if folded[SignatureDataKey] != conf[SignatureDataKey] {
+		t.Fatal("b= value did not round-trip through folding")
+	}
+}
+
+func TestSignPreservesRepeatedHeaders(t *testing.T) {
+	d, resolver := newTestDKIM(t)
+
+	eml := "Received: from a\r\n" +
+		"Received: from b\r\n" +
+		verifySampleEML
+
+	signed, err := d.Sign([]byte(eml))
+	if err != nil {
+		t.Fatal("error signing", err)
+	}
+	if bytes.Count(signed, []byte("Received:")) != 2 {
+		t.Fatal("expected both Received headers to survive signing", string(signed))
+	}
+
+	result, err := Verify(signed, resolver)
+	if err != nil {
+		t.Fatal("error verifying", err)
+	}
+	if result.Code != SUCCESS {
+		t.Fatal("expected SUCCESS, got", result.Code, result.Reason)
+	}
+}
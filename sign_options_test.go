@@ -0,0 +1,96 @@
+package dkim
+
+import (
+	"bytes"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// signedConf parses the DKIM-Signature header out of a message Sign
+// produced, the way a caller (or Verify) would, rather than reaching
+// into *DKIM's internal Conf - which is private per-message state since
+// a *DKIM may be signing other messages concurrently.
+func signedConf(t *testing.T, signed []byte) Conf {
+	t.Helper()
+
+	header, _ := splitHeaderAndBody(signed)
+	conf, err := ParseConf(strings.TrimPrefix(string(header[bytes.Index(header, []byte(SignatureHeaderKey+":")):]), SignatureHeaderKey+":"))
+	if err != nil {
+		t.Fatal("error parsing signed header", err)
+	}
+
+	return conf
+}
+
+func TestSignBodyLength(t *testing.T) {
+	d, resolver := newTestDKIM(t)
+	d.SetBodyLength(5)
+
+	signed, err := d.Sign([]byte(verifySampleEML))
+	if err != nil {
+		t.Fatal("error signing", err)
+	}
+	if got := signedConf(t, signed)[BodyLengthKey]; got != "5" {
+		t.Fatal("expected l=5, got", got)
+	}
+
+	result, err := Verify(signed, resolver)
+	if err != nil {
+		t.Fatal("error verifying", err)
+	}
+	if result.Code != SUCCESS {
+		t.Fatal("expected SUCCESS, got", result.Code, result.Reason)
+	}
+}
+
+func TestSignTimestampAndExpiration(t *testing.T) {
+	d, resolver := newTestDKIM(t)
+	d.SetSignatureExpireIn(time.Hour)
+
+	signed, err := d.Sign([]byte(verifySampleEML))
+	if err != nil {
+		t.Fatal("error signing", err)
+	}
+	conf := signedConf(t, signed)
+
+	ts, err := strconv.ParseInt(conf[TimestampKey], 10, 64)
+	if err != nil {
+		t.Fatal("expected a t= tag to be set", conf[TimestampKey])
+	}
+	exp, err := strconv.ParseInt(conf[ExpirationKey], 10, 64)
+	if err != nil {
+		t.Fatal("expected an x= tag to be set", conf[ExpirationKey])
+	}
+	if exp-ts != int64(time.Hour.Seconds()) {
+		t.Fatal("expected x= to be t= plus one hour", ts, exp)
+	}
+
+	result, err := Verify(signed, resolver)
+	if err != nil {
+		t.Fatal("error verifying", err)
+	}
+	if result.Code != SUCCESS {
+		t.Fatal("expected SUCCESS, got", result.Code, result.Reason)
+	}
+}
+
+func TestVerifyExpiredSignature(t *testing.T) {
+	d, resolver := newTestDKIM(t)
+	d.SetSignatureExpireIn(time.Second)
+
+	signed, err := d.Sign([]byte(verifySampleEML))
+	if err != nil {
+		t.Fatal("error signing", err)
+	}
+	time.Sleep(2 * time.Second)
+
+	result, err := Verify(signed, resolver)
+	if err != nil {
+		t.Fatal("error verifying", err)
+	}
+	if result.Code != PERMFAIL {
+		t.Fatal("expected PERMFAIL for an expired signature, got", result.Code)
+	}
+}
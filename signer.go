@@ -0,0 +1,249 @@
+package dkim
+
+import (
+	"bufio"
+	"bytes"
+	"hash"
+	"io"
+	"net/mail"
+	"net/textproto"
+	"regexp"
+	"strings"
+)
+
+var crlf = []byte("\r\n")
+
+// bodyWSPRx collapses runs of body whitespace to a single space for
+// relaxed canonicalization, mirroring the regexp canonicalizeBody uses
+// for the same purpose.
+var bodyWSPRx = regexp.MustCompile(`[ \t]+`)
+
+// Signer incrementally canonicalizes and hashes a message body as it is
+// streamed through Write, instead of requiring the whole body to be
+// buffered in memory the way Sign does. It is meant for large messages,
+// where reading the body into a single []byte first would be wasteful.
+//
+// Use NewSigner to obtain one, write the body to it, and call Close to
+// get the finished DKIM-Signature header to prepend to the message.
+type Signer struct {
+	d         *DKIM
+	header    mail.Header
+	rawHeader []byte
+	canon     *bodyCanonicalizer
+}
+
+// NewSigner parses headers - a header block in the form returned by
+// splitHeaderAndBody, i.e. ending after the last header line without the
+// blank line that separates it from the body - and returns a Signer
+// ready to have the message body streamed through its Write method.
+func (d *DKIM) NewSigner(headers io.Reader) (*Signer, error) {
+	raw, err := io.ReadAll(headers)
+	if err != nil {
+		return nil, err
+	}
+
+	tp := textproto.NewReader(bufio.NewReader(io.MultiReader(bytes.NewReader(raw), strings.NewReader("\r\n"))))
+	hdr, err := tp.ReadMIMEHeader()
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+
+	return &Signer{
+		d:         d,
+		header:    mail.Header(hdr),
+		rawHeader: raw,
+		canon:     newBodyCanonicalizer(d.conf.Hash().New(), d.conf.RelaxedBody(), d.bodyLength),
+	}, nil
+}
+
+// Write feeds another chunk of the message body through the streaming
+// canonicalizer, updating the running body hash. It never returns an
+// error; len(p) is always reported written.
+func (s *Signer) Write(p []byte) (int, error) {
+	s.canon.write(p)
+	return len(p), nil
+}
+
+// Close finalizes the body hash, signs the message and returns the
+// folded DKIM-Signature header to send along with the original headers
+// and the body already streamed through Write. It's safe to call
+// concurrently with other Signers from the same *DKIM, since the Conf it
+// builds is private to this call rather than shared state on *DKIM.
+func (s *Signer) Close() (string, error) {
+	msg := &mail.Message{Header: s.header}
+	conf, block := s.d.signableHeaderBlockWithBodyHash(msg, s.rawHeader, s.canon.sum())
+
+	sig, err := s.d.signBlock(conf, block)
+	if err != nil {
+		return "", err
+	}
+	conf[SignatureDataKey] = sig
+
+	return conf.Header(), nil
+}
+
+// bodyCanonicalizer applies the body canonicalization algorithm (RFC
+// 6376 section 3.4.3/3.4.4) to a body fed to it incrementally via write,
+// hashing the canonical octets as they become known instead of
+// buffering the whole body. Besides the current, not-yet-terminated
+// line, it holds back the most recently completed non-blank line (and
+// any blank lines after it) rather than emitting it immediately, since
+// whether the body has any more non-blank lines after it - and so
+// whether it's actually the body's last line - isn't known until either
+// another non-blank line arrives or write has no more input, at which
+// point sum decides what to do with it.
+type bodyCanonicalizer struct {
+	digest     hash.Hash
+	relaxed    bool
+	bodyLength int64
+	written    int64
+	inputLen   int64
+
+	line  []byte
+	sawCR bool
+
+	pending     []byte
+	havePending bool
+	blank       int
+	any         bool
+}
+
+func newBodyCanonicalizer(digest hash.Hash, relaxed bool, bodyLength int64) *bodyCanonicalizer {
+	return &bodyCanonicalizer{digest: digest, relaxed: relaxed, bodyLength: bodyLength}
+}
+
+func (c *bodyCanonicalizer) write(p []byte) {
+	c.inputLen += int64(len(p))
+
+	for _, b := range p {
+		if c.sawCR && b != '\n' {
+			c.line = append(c.line, '\r')
+			c.sawCR = false
+		}
+
+		switch b {
+		case '\r':
+			c.sawCR = true
+		case '\n':
+			c.sawCR = false
+			c.endLine()
+		default:
+			c.line = append(c.line, b)
+		}
+	}
+}
+
+func (c *bodyCanonicalizer) endLine() {
+	line := c.canonicalLine(c.line)
+	c.line = c.line[:0]
+	c.observe(line)
+}
+
+// observe disposes of one canonicalized line: a blank one is buffered,
+// since it might turn out to be part of a run of empty lines at the end
+// of the message, and a non-blank one replaces the held-back pending
+// line, releasing the old one (now known not to be the last line) and
+// any blank lines that followed it.
+func (c *bodyCanonicalizer) observe(line []byte) {
+	if len(line) == 0 {
+		c.blank++
+		return
+	}
+
+	c.releasePending()
+	// Copied, since under "simple" line still shares c.line's buffer,
+	// which the next line is written into.
+	c.pending, c.havePending = append(c.pending[:0], line...), true
+	c.any = true
+	c.blank = 0
+}
+
+// canonicalLine applies per-line canonicalization that doesn't depend on
+// the line's position in the body: WSP-collapsing and trailing-WSP
+// trimming for "relaxed", nothing for "simple" (which leaves every line,
+// the body's last one included, untouched).
+func (c *bodyCanonicalizer) canonicalLine(line []byte) []byte {
+	if !c.relaxed {
+		return line
+	}
+
+	line = bodyWSPRx.ReplaceAll(line, []byte(" "))
+
+	return bytes.TrimRight(line, " ")
+}
+
+// releasePending emits the held-back line verbatim, now known not to be
+// the body's last line, followed by any blank lines buffered after it.
+func (c *bodyCanonicalizer) releasePending() {
+	if c.havePending {
+		c.emit(c.pending)
+		c.emit(crlf)
+		c.havePending = false
+	}
+	for ; c.blank > 0; c.blank-- {
+		c.emit(crlf)
+	}
+}
+
+// emit hashes b, truncating it (and anything after it) once bodyLength
+// octets of canonical body have been hashed, the streaming equivalent of
+// the l= truncation canonicalBody applies to an already-buffered body.
+func (c *bodyCanonicalizer) emit(b []byte) {
+	if c.bodyLength > 0 {
+		remaining := c.bodyLength - c.written
+		if remaining <= 0 {
+			return
+		}
+		if int64(len(b)) > remaining {
+			b = b[:remaining]
+		}
+	}
+
+	c.digest.Write(b)
+	c.written += int64(len(b))
+}
+
+// sum finalizes the canonical body and returns its hash: any
+// unterminated final line is canonicalized as if it had a terminator,
+// any still-buffered trailing blank lines are discarded, and the body's
+// true last line (if any) has its trailing whitespace trimmed only under
+// "relaxed" - matching canonicalizeBody, which leaves a "simple" body's
+// last line untouched - before being emitted, per RFC 6376 section
+// 3.4.3/3.4.4.
+func (c *bodyCanonicalizer) sum() []byte {
+	if c.sawCR {
+		c.line = append(c.line, '\r')
+		c.sawCR = false
+	}
+	if len(c.line) > 0 {
+		c.observe(c.canonicalLine(c.line))
+		c.line = nil
+	}
+
+	// Trailing blank lines are never part of the canonical body, so
+	// whatever is still buffered here is dropped rather than released.
+	c.blank = 0
+
+	switch {
+	case c.inputLen == 0:
+		// A zero-length body canonicalizes to a single CRLF for "simple",
+		// but canonicalizeBody returns it untouched (i.e. still empty) for
+		// "relaxed"; matched here so streaming and buffered signing agree.
+		if !c.relaxed {
+			c.emit(crlf)
+		}
+	case c.havePending:
+		// canonicalLine already trimmed trailing WSP for "relaxed"; in
+		// "simple" the last line must survive untouched.
+		last := c.pending
+		if c.relaxed {
+			last = bytes.TrimRight(last, " \t")
+		}
+		c.emit(last)
+		c.emit(crlf)
+	case !c.any:
+		c.emit(crlf)
+	}
+
+	return c.digest.Sum(nil)
+}
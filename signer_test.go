@@ -0,0 +1,218 @@
+package dkim
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestNewSignerVerifies(t *testing.T) {
+	d, resolver := newTestDKIM(t)
+
+	header, body := splitHeaderAndBody([]byte(verifySampleEML))
+
+	s, err := d.NewSigner(bytes.NewReader(header))
+	if err != nil {
+		t.Fatal("error creating signer", err)
+	}
+	if _, err := s.Write(body); err != nil {
+		t.Fatal("error writing body", err)
+	}
+	sigHeader, err := s.Close()
+	if err != nil {
+		t.Fatal("error closing signer", err)
+	}
+
+	signed := append(append(append([]byte{}, header...), []byte(sigHeader+"\r\n\r\n")...), body...)
+
+	result, err := Verify(signed, resolver)
+	if err != nil {
+		t.Fatal("error verifying", err)
+	}
+	if result.Code != SUCCESS {
+		t.Fatal("expected SUCCESS, got", result.Code, result.Reason)
+	}
+}
+
+// TestNewSignerReused signs two messages in a row with the same *DKIM, the
+// way a long-lived signer would be used in practice, to make sure the
+// second signature doesn't hash in the b= value left over from the first.
+func TestNewSignerReused(t *testing.T) {
+	d, resolver := newTestDKIM(t)
+	header, body := splitHeaderAndBody([]byte(verifySampleEML))
+
+	for i := 0; i < 2; i++ {
+		s, err := d.NewSigner(bytes.NewReader(header))
+		if err != nil {
+			t.Fatal("error creating signer", err)
+		}
+		if _, err := s.Write(body); err != nil {
+			t.Fatal("error writing body", err)
+		}
+		sigHeader, err := s.Close()
+		if err != nil {
+			t.Fatal("error closing signer", err)
+		}
+
+		signed := append(append(append([]byte{}, header...), []byte(sigHeader+"\r\n\r\n")...), body...)
+
+		result, err := Verify(signed, resolver)
+		if err != nil {
+			t.Fatal("error verifying", err)
+		}
+		if result.Code != SUCCESS {
+			t.Fatalf("sign #%d: expected SUCCESS, got %s %s", i, result.Code, result.Reason)
+		}
+	}
+}
+
+// TestNewSignerChunkedBody writes a body with whitespace runs and
+// trailing blank lines one byte at a time, to exercise the streaming
+// canonicalizer across arbitrary write boundaries.
+func TestNewSignerChunkedBody(t *testing.T) {
+	d, resolver := newTestDKIM(t)
+
+	eml := "From: \"Fook\" <fook@haiyiyun.com>\r\n" +
+		"To: \"fook\" <fook@haiyiyun.com>\r\n" +
+		"Subject: Hello fook\r\n" +
+		"\r\n" +
+		"This   is    an email   \r\n" +
+		"\r\n" +
+		"\r\n"
+	header, body := splitHeaderAndBody([]byte(eml))
+
+	s, err := d.NewSigner(bytes.NewReader(header))
+	if err != nil {
+		t.Fatal("error creating signer", err)
+	}
+	for i := range body {
+		if _, err := s.Write(body[i : i+1]); err != nil {
+			t.Fatal("error writing body", err)
+		}
+	}
+	sigHeader, err := s.Close()
+	if err != nil {
+		t.Fatal("error closing signer", err)
+	}
+
+	signed := append(append(append([]byte{}, header...), []byte(sigHeader+"\r\n\r\n")...), body...)
+
+	result, err := Verify(signed, resolver)
+	if err != nil {
+		t.Fatal("error verifying", err)
+	}
+	if result.Code != SUCCESS {
+		t.Fatal("expected SUCCESS, got", result.Code, result.Reason)
+	}
+}
+
+// TestNewSignerConcurrent signs many messages at once through the same
+// *DKIM, the way a long-lived proxy/milter component would use it, to
+// make sure concurrent Signers don't race on shared state (run with
+// -race) or corrupt each other's signatures.
+func TestNewSignerConcurrent(t *testing.T) {
+	d, resolver := newTestDKIM(t)
+	d.SetTimestamp(true)
+	header, body := splitHeaderAndBody([]byte(verifySampleEML))
+
+	const n = 20
+	var wg sync.WaitGroup
+	errs := make([]error, n)
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+
+			s, err := d.NewSigner(bytes.NewReader(header))
+			if err != nil {
+				errs[i] = err
+				return
+			}
+			if _, err := s.Write(body); err != nil {
+				errs[i] = err
+				return
+			}
+			sigHeader, err := s.Close()
+			if err != nil {
+				errs[i] = err
+				return
+			}
+
+			signed := append(append(append([]byte{}, header...), []byte(sigHeader+"\r\n\r\n")...), body...)
+			result, err := Verify(signed, resolver)
+			if err != nil {
+				errs[i] = err
+				return
+			}
+			if result.Code != SUCCESS {
+				errs[i] = fmt.Errorf("expected SUCCESS, got %s %s", result.Code, result.Reason)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	for i, err := range errs {
+		if err != nil {
+			t.Fatalf("goroutine %d: %v", i, err)
+		}
+	}
+}
+
+// TestNewSignerSimpleMatchesBuffered signs the same body, with trailing
+// whitespace on its last line, both through NewSigner and through Sign,
+// to make sure the streaming canonicalizer's bh= matches the buffered
+// one under "simple" body canonicalization, where that trailing
+// whitespace must survive untouched in both.
+func TestNewSignerSimpleMatchesBuffered(t *testing.T) {
+	d, resolver := newTestDKIM(t)
+	d.conf[CanonicalizationKey] = "relaxed/simple"
+
+	eml := "From: \"Fook\" <fook@haiyiyun.com>\r\n" +
+		"To: \"fook\" <fook@haiyiyun.com>\r\n" +
+		"Subject: Hello fook\r\n" +
+		"\r\n" +
+		"This is an email   \r\n" +
+		"\r\n"
+	header, body := splitHeaderAndBody([]byte(eml))
+
+	buffered, err := d.Sign([]byte(eml))
+	if err != nil {
+		t.Fatal("error signing", err)
+	}
+	bufferedHeader, _ := splitHeaderAndBody(buffered)
+	bufferedConf, err := ParseConf(strings.TrimPrefix(string(bufferedHeader[bytes.Index(bufferedHeader, []byte(SignatureHeaderKey+":")):]), SignatureHeaderKey+":"))
+	if err != nil {
+		t.Fatal("error parsing signed header", err)
+	}
+
+	s, err := d.NewSigner(bytes.NewReader(header))
+	if err != nil {
+		t.Fatal("error creating signer", err)
+	}
+	if _, err := s.Write(body); err != nil {
+		t.Fatal("error writing body", err)
+	}
+	sigHeader, err := s.Close()
+	if err != nil {
+		t.Fatal("error closing signer", err)
+	}
+	streamedConf, err := ParseConf(strings.TrimPrefix(sigHeader, SignatureHeaderKey+":"))
+	if err != nil {
+		t.Fatal("error parsing streamed header", err)
+	}
+
+	if bufferedConf[BodyHashKey] != streamedConf[BodyHashKey] {
+		t.Fatalf("bh= mismatch between buffered and streamed signing: %q vs %q", bufferedConf[BodyHashKey], streamedConf[BodyHashKey])
+	}
+
+	signed := append(append(append([]byte{}, header...), []byte(sigHeader+"\r\n\r\n")...), body...)
+	result, err := Verify(signed, resolver)
+	if err != nil {
+		t.Fatal("error verifying", err)
+	}
+	if result.Code != SUCCESS {
+		t.Fatal("expected SUCCESS, got", result.Code, result.Reason)
+	}
+}
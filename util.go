@@ -0,0 +1,109 @@
+package dkim
+
+import (
+	"bytes"
+	"fmt"
+	"net/mail"
+	"strings"
+)
+
+func readEML(eml []byte) (*mail.Message, error) {
+	return mail.ReadMessage(bytes.NewReader(eml))
+}
+
+// splitHeaderAndBody splits eml into its raw header block (including the
+// trailing CRLF of the last header line, but not the blank line that ends
+// it) and its body, without going through mail.Header, so that the
+// original header bytes - ordering, casing and any repeated field names -
+// survive untouched.
+func splitHeaderAndBody(eml []byte) (header, body []byte) {
+	if i := bytes.Index(eml, []byte("\r\n\r\n")); i >= 0 {
+		return eml[:i+2], eml[i+4:]
+	}
+	if i := bytes.Index(eml, []byte("\n\n")); i >= 0 {
+		return eml[:i+1], eml[i+2:]
+	}
+
+	return eml, nil
+}
+
+// rawHeaderField is one header field exactly as it appeared in the
+// original message: its name and its full text (including any folded
+// continuation lines), with the same byte-for-byte casing and
+// whitespace it had on the wire.
+type rawHeaderField struct {
+	name string
+	raw  string
+}
+
+// parseRawHeaderFields splits a raw header block (as returned by
+// splitHeaderAndBody) into its individual fields, continuation lines
+// included, without going through mail.Header - which unconditionally
+// canonicalizes field-name casing and unfolds continuation lines into a
+// single line - so that "simple" header canonicalization (RFC 6376
+// section 3.4.1), which must not touch either, has the actual wire
+// bytes to work with.
+func parseRawHeaderFields(header []byte) []rawHeaderField {
+	nl := "\n"
+	text := string(header)
+	if strings.Contains(text, "\r\n") {
+		nl = "\r\n"
+	}
+	text = strings.TrimSuffix(text, nl)
+	if text == "" {
+		return nil
+	}
+
+	var fields []rawHeaderField
+	for _, line := range strings.Split(text, nl) {
+		if len(fields) > 0 && len(line) > 0 && (line[0] == ' ' || line[0] == '\t') {
+			fields[len(fields)-1].raw += nl + line
+			continue
+		}
+
+		name := line
+		if i := strings.IndexByte(line, ':'); i >= 0 {
+			name = line[:i]
+		}
+		fields = append(fields, rawHeaderField{name: name, raw: line})
+	}
+
+	return fields
+}
+
+// rawFieldValues returns, in the order they appear in fields, the raw
+// text of every field whose name matches name case-insensitively.
+func rawFieldValues(fields []rawHeaderField, name string) []string {
+	var values []string
+	for _, f := range fields {
+		if strings.EqualFold(f.name, name) {
+			values = append(values, f.raw)
+		}
+	}
+
+	return values
+}
+
+// parseTagList parses a DKIM tag-list, e.g. "tag1=value1; tag2 = value2",
+// as used by both DKIM-Signature headers and DNS key records (RFC 6376
+// section 3.2). Folding whitespace within a value is removed, matching
+// the handling required for the b= and bh= tags.
+func parseTagList(raw string) (map[string]string, error) {
+	tags := make(map[string]string)
+	for _, part := range strings.Split(raw, ";") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+
+		kv := strings.SplitN(part, "=", 2)
+		if len(kv) != 2 {
+			return nil, fmt.Errorf("dkim: malformed tag %q", part)
+		}
+
+		key := strings.TrimSpace(kv[0])
+		tags[key] = strings.Join(strings.Fields(kv[1]), "")
+	}
+
+	return tags, nil
+}
@@ -0,0 +1,385 @@
+package dkim
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net"
+	"net/mail"
+	"net/textproto"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// VerifyCode is the outcome of verifying a DKIM-Signature header, using
+// the PERMFAIL/TEMPFAIL vocabulary of RFC 6376 section 6.3.
+type VerifyCode string
+
+const (
+	SUCCESS   VerifyCode = "SUCCESS"
+	PERMFAIL  VerifyCode = "PERMFAIL"
+	TEMPFAIL  VerifyCode = "TEMPFAIL"
+	NOTSIGNED VerifyCode = "NOTSIGNED"
+)
+
+// SignatureResult is the verification outcome of a single DKIM-Signature
+// header found on a message.
+type SignatureResult struct {
+	Domain   string
+	Selector string
+	Code     VerifyCode
+	Reason   string
+
+	// Testing is true when the signer's DNS key record carried the "t=y"
+	// flag, meaning the signer doesn't trust this selector's signatures
+	// in production yet (RFC 6376 section 3.6.1); callers may want to
+	// treat a PERMFAIL on a testing key more leniently than a regular one.
+	Testing bool
+}
+
+// VerifyResult is the outcome of verifying a message. Code summarizes the
+// Signatures: SUCCESS if at least one signature verified, NOTSIGNED if the
+// message carried no DKIM-Signature header, otherwise TEMPFAIL if any
+// signature failed temporarily, and PERMFAIL if none did.
+type VerifyResult struct {
+	Code       VerifyCode
+	Reason     string
+	Signatures []*SignatureResult
+}
+
+// Resolver resolves DNS TXT records. It exists so tests can substitute a
+// fake implementation instead of hitting real nameservers.
+type Resolver interface {
+	LookupTXT(domain string) ([]string, error)
+}
+
+type dnsResolver struct{}
+
+func (dnsResolver) LookupTXT(domain string) ([]string, error) {
+	return net.LookupTXT(domain)
+}
+
+// SetResolver overrides the DNS resolver d uses to fetch signers' public
+// keys, primarily so tests don't need to hit a real nameserver.
+func (d *DKIM) SetResolver(r Resolver) {
+	d.resolver = r
+}
+
+// Verify checks every DKIM-Signature header on eml against its signer's
+// published public key, using d's configured DNS resolver.
+func (d *DKIM) Verify(eml []byte) (*VerifyResult, error) {
+	return verify(eml, d.resolver)
+}
+
+// Verify checks every DKIM-Signature header on eml against its signer's
+// published public key. Unlike (*DKIM).Verify, it doesn't need a
+// preconfigured signer; pass nil to use the default net.LookupTXT resolver.
+func Verify(eml []byte, resolver Resolver) (*VerifyResult, error) {
+	if resolver == nil {
+		resolver = dnsResolver{}
+	}
+
+	return verify(eml, resolver)
+}
+
+func verify(eml []byte, resolver Resolver) (*VerifyResult, error) {
+	msg, err := readEML(eml)
+	if err != nil {
+		return nil, err
+	}
+
+	body := new(bytes.Buffer)
+	body.ReadFrom(msg.Body)
+	bodyb := body.Bytes()
+
+	// rawHeader feeds "simple" header canonicalization, which must hash
+	// the header's exact wire bytes rather than msg.Header's re-cased,
+	// unfolded copy.
+	rawHeader, _ := splitHeaderAndBody(eml)
+
+	sigKey := textproto.CanonicalMIMEHeaderKey(SignatureHeaderKey)
+	rawSigs := msg.Header[sigKey]
+	if len(rawSigs) == 0 {
+		return &VerifyResult{Code: NOTSIGNED, Reason: "message has no DKIM-Signature header"}, nil
+	}
+
+	result := &VerifyResult{Signatures: make([]*SignatureResult, 0, len(rawSigs))}
+	for _, raw := range rawSigs {
+		result.Signatures = append(result.Signatures, verifySignature(msg.Header, rawHeader, bodyb, raw, resolver))
+	}
+
+	result.Code, result.Reason = summarize(result.Signatures)
+
+	return result, nil
+}
+
+func summarize(sigs []*SignatureResult) (VerifyCode, string) {
+	worst := PERMFAIL
+	reason := ""
+	for _, s := range sigs {
+		if s.Code == SUCCESS {
+			return SUCCESS, ""
+		}
+		if s.Code == TEMPFAIL {
+			worst, reason = TEMPFAIL, s.Reason
+		} else if reason == "" {
+			reason = s.Reason
+		}
+	}
+
+	return worst, reason
+}
+
+func verifySignature(header mail.Header, rawHeader, bodyb []byte, raw string, resolver Resolver) *SignatureResult {
+	conf, err := ParseConf(raw)
+	if err != nil {
+		return &SignatureResult{Code: PERMFAIL, Reason: err.Error()}
+	}
+
+	result := &SignatureResult{Domain: conf[DomainKey], Selector: conf[SelectorKey]}
+
+	if v := conf[VersionKey]; v != "" && v != "1" {
+		result.Code, result.Reason = PERMFAIL, fmt.Sprintf("unsupported signature version %q", v)
+		return result
+	}
+	for _, required := range []string{DomainKey, SelectorKey, FieldsKey, BodyHashKey, SignatureDataKey} {
+		if conf[required] == "" {
+			result.Code, result.Reason = PERMFAIL, fmt.Sprintf("missing required tag %q", required)
+			return result
+		}
+	}
+
+	if x := conf[ExpirationKey]; x != "" {
+		expires, err := strconv.ParseInt(x, 10, 64)
+		if err != nil {
+			result.Code, result.Reason = PERMFAIL, "malformed x= tag"
+			return result
+		}
+		if time.Now().Unix() > expires {
+			result.Code, result.Reason = PERMFAIL, "signature expired"
+			return result
+		}
+	}
+
+	key, err := lookupPublicKey(conf[SelectorKey], conf[DomainKey], resolver)
+	if err != nil {
+		if tf, ok := err.(tempError); ok {
+			result.Code, result.Reason = TEMPFAIL, tf.Error()
+		} else {
+			result.Code, result.Reason = PERMFAIL, err.Error()
+		}
+		return result
+	}
+	result.Testing = key.testing
+	pub := key.pub
+
+	canonHeader, canonBody := conf.canonicalization()
+
+	body := canonicalizeBody(bodyb, canonBody == "relaxed")
+	if l := conf[BodyLengthKey]; l != "" {
+		n, err := strconv.ParseInt(l, 10, 64)
+		if err != nil || n < 0 {
+			result.Code, result.Reason = PERMFAIL, "malformed l= tag"
+			return result
+		}
+		if n < int64(len(body)) {
+			body = body[:n]
+		}
+	}
+
+	digest := conf.Hash().New()
+	digest.Write(body)
+	if base64.StdEncoding.EncodeToString(digest.Sum(nil)) != conf[BodyHashKey] {
+		result.Code, result.Reason = PERMFAIL, "body hash mismatch"
+		return result
+	}
+
+	block, err := canonicalSignedHeaderBlock(header, rawHeader, conf, canonHeader == "relaxed")
+	if err != nil {
+		result.Code, result.Reason = PERMFAIL, err.Error()
+		return result
+	}
+
+	sig, err := base64.StdEncoding.DecodeString(conf[SignatureDataKey])
+	if err != nil {
+		result.Code, result.Reason = PERMFAIL, "malformed b= tag"
+		return result
+	}
+
+	headerDigest := conf.Hash().New()
+	headerDigest.Write([]byte(block))
+
+	if err := verifySignatureBytes(conf[AlgorithmKey], pub, conf.Hash(), headerDigest.Sum(nil), sig); err != nil {
+		result.Code, result.Reason = PERMFAIL, err.Error()
+		return result
+	}
+
+	result.Code = SUCCESS
+
+	return result
+}
+
+// verifySignatureBytes checks sig over digest using the public key
+// matching algo's key type (the prefix of the a= tag, before "-").
+func verifySignatureBytes(algo string, pub crypto.PublicKey, hash crypto.Hash, digest, sig []byte) error {
+	switch {
+	case strings.HasPrefix(algo, "ed25519"):
+		edPub, ok := pub.(ed25519.PublicKey)
+		if !ok {
+			return errors.New("key type does not match a= algorithm")
+		}
+		if !ed25519.Verify(edPub, digest, sig) {
+			return errors.New("signature verification failed")
+		}
+	case strings.HasPrefix(algo, "rsa"), algo == "":
+		rsaPub, ok := pub.(*rsa.PublicKey)
+		if !ok {
+			return errors.New("key type does not match a= algorithm")
+		}
+		if err := rsa.VerifyPKCS1v15(rsaPub, hash, digest, sig); err != nil {
+			return errors.New("signature verification failed")
+		}
+	default:
+		return fmt.Errorf("unsupported signature algorithm %q", algo)
+	}
+
+	return nil
+}
+
+// canonicalSignedHeaderBlock reconstructs the exact bytes that were hashed
+// at signing time: the fields named by h=, taken from the bottom-most
+// unused occurrence when a field name repeats, followed by the
+// DKIM-Signature header itself with its b= value removed. rawHeader is
+// the original header block byte for byte (as returned by
+// splitHeaderAndBody); it's what "simple" header canonicalization hashes,
+// since header (a mail.Header) has already re-cased field names and
+// unfolded continuation lines by the time it reaches here.
+func canonicalSignedHeaderBlock(header mail.Header, rawHeader []byte, conf Conf, relaxed bool) (string, error) {
+	rawFields := parseRawHeaderFields(rawHeader)
+
+	used := make(map[string]int)
+	lines := make([]string, 0)
+
+	for _, name := range strings.Split(conf[FieldsKey], ":") {
+		name = strings.TrimSpace(name)
+		key := textproto.CanonicalMIMEHeaderKey(name)
+		values := header[key]
+		skip := used[key]
+		idx := len(values) - 1 - skip
+		if idx < 0 {
+			continue
+		}
+		used[key] = skip + 1
+
+		raw := rawFieldValues(rawFields, name)
+		if !relaxed && idx < len(raw) {
+			lines = append(lines, raw[idx])
+			continue
+		}
+		lines = append(lines, canonicalizeHeaderField(name, values[idx], relaxed))
+	}
+
+	// The b= tag is part of the hashed header block, but with its value
+	// blanked out (RFC 6376 section 3.7); rebuild the tag list that way
+	// rather than hashing the signature over itself.
+	unsigned := conf.Clone()
+	unsigned[SignatureDataKey] = ""
+	lines = append(lines, canonicalizeHeaderField(SignatureHeaderKey, unsigned.String(), relaxed))
+
+	return strings.Join(lines, "\r\n"), nil
+}
+
+type tempError string
+
+func (e tempError) Error() string { return string(e) }
+
+// dnsPublicKey is a signer's public key together with the flags carried
+// alongside it in its DNS key record (RFC 6376 section 3.6.1).
+type dnsPublicKey struct {
+	pub     crypto.PublicKey
+	testing bool
+}
+
+func lookupPublicKey(selector, domain string, resolver Resolver) (*dnsPublicKey, error) {
+	records, err := resolver.LookupTXT(selector + "._domainkey." + domain)
+	if err != nil {
+		return nil, tempError(err.Error())
+	}
+	if len(records) == 0 {
+		return nil, fmt.Errorf("no DNS TXT record found for %s._domainkey.%s", selector, domain)
+	}
+	if len(records) > 1 {
+		return nil, fmt.Errorf("multiple DNS TXT records found for %s._domainkey.%s", selector, domain)
+	}
+
+	tags, err := parseTagList(records[0])
+	if err != nil {
+		return nil, err
+	}
+
+	if v := tags["v"]; v != "" && v != "DKIM1" {
+		return nil, fmt.Errorf("unsupported DKIM key record version %q", v)
+	}
+
+	p := tags["p"]
+	if p == "" {
+		return nil, errors.New("key revoked")
+	}
+
+	if s := tags["s"]; s != "" {
+		allowed := false
+		for _, typ := range strings.Split(s, ":") {
+			if typ = strings.TrimSpace(typ); typ == "*" || typ == "email" {
+				allowed = true
+				break
+			}
+		}
+		if !allowed {
+			return nil, fmt.Errorf("key record restricts s= to %q, not email", s)
+		}
+	}
+
+	testing := false
+	for _, flag := range strings.Split(tags["t"], ":") {
+		if strings.TrimSpace(flag) == "y" {
+			testing = true
+			break
+		}
+	}
+
+	raw, err := base64.StdEncoding.DecodeString(p)
+	if err != nil {
+		return nil, fmt.Errorf("malformed p= tag: %w", err)
+	}
+
+	keyType := tags["k"]
+	if keyType == "" {
+		keyType = "rsa"
+	}
+
+	switch keyType {
+	case "rsa":
+		pub, err := x509.ParsePKIXPublicKey(raw)
+		if err != nil {
+			return nil, fmt.Errorf("malformed public key: %w", err)
+		}
+		rsaPub, ok := pub.(*rsa.PublicKey)
+		if !ok {
+			return nil, errors.New("public key is not an RSA key")
+		}
+		return &dnsPublicKey{pub: rsaPub, testing: testing}, nil
+	case "ed25519":
+		if len(raw) != ed25519.PublicKeySize {
+			return nil, errors.New("malformed ed25519 public key")
+		}
+		return &dnsPublicKey{pub: ed25519.PublicKey(raw), testing: testing}, nil
+	default:
+		return nil, fmt.Errorf("unsupported DKIM key type %q", keyType)
+	}
+}
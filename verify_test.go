@@ -0,0 +1,137 @@
+package dkim
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"testing"
+)
+
+var verifySampleEML = "From: \"Fook\" <fook@haiyiyun.com>\r\n" +
+	"To: \"fook\" <fook@haiyiyun.com>\r\n" +
+	"Subject: Hello fook\r\n" +
+	"\r\n" +
+	"This is an email\r\n"
+
+type fakeResolver map[string][]string
+
+func (f fakeResolver) LookupTXT(domain string) ([]string, error) {
+	return f[domain], nil
+}
+
+func newTestDKIM(t *testing.T) (*DKIM, fakeResolver) {
+	key, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		t.Fatal("error generating key", err)
+	}
+	keyPEM := pem.EncodeToMemory(&pem.Block{
+		Type:  "RSA PRIVATE KEY",
+		Bytes: x509.MarshalPKCS1PrivateKey(key),
+	})
+
+	conf := NewConf("haiyiyun.com", "default")
+	d, err := New(conf, keyPEM)
+	if err != nil {
+		t.Fatal("error creating signer", err)
+	}
+
+	der, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	if err != nil {
+		t.Fatal("error marshaling public key", err)
+	}
+	record := "v=DKIM1; k=rsa; p=" + base64.StdEncoding.EncodeToString(der)
+	resolver := fakeResolver{"default._domainkey.haiyiyun.com": []string{record}}
+
+	return d, resolver
+}
+
+func TestVerifySuccess(t *testing.T) {
+	d, resolver := newTestDKIM(t)
+
+	signed, err := d.Sign([]byte(verifySampleEML))
+	if err != nil {
+		t.Fatal("error signing", err)
+	}
+
+	result, err := Verify(signed, resolver)
+	if err != nil {
+		t.Fatal("error verifying", err)
+	}
+	if result.Code != SUCCESS {
+		t.Fatal("expected SUCCESS, got", result.Code, result.Reason)
+	}
+	if len(result.Signatures) != 1 || result.Signatures[0].Code != SUCCESS {
+		t.Fatal("expected one successful signature result", result.Signatures)
+	}
+}
+
+func TestVerifyTamperedBody(t *testing.T) {
+	d, resolver := newTestDKIM(t)
+
+	signed, err := d.Sign([]byte(verifySampleEML))
+	if err != nil {
+		t.Fatal("error signing", err)
+	}
+
+	tampered := append([]byte{}, signed...)
+	tampered = []byte(string(tampered) + "extra line\r\n")
+
+	result, err := Verify(tampered, resolver)
+	if err != nil {
+		t.Fatal("error verifying", err)
+	}
+	if result.Code != PERMFAIL {
+		t.Fatal("expected PERMFAIL, got", result.Code)
+	}
+}
+
+func TestVerifySuccessSurfacesTestingKey(t *testing.T) {
+	d, resolver := newTestDKIM(t)
+	resolver["default._domainkey.haiyiyun.com"][0] += "; t=y"
+
+	signed, err := d.Sign([]byte(verifySampleEML))
+	if err != nil {
+		t.Fatal("error signing", err)
+	}
+
+	result, err := Verify(signed, resolver)
+	if err != nil {
+		t.Fatal("error verifying", err)
+	}
+	if result.Code != SUCCESS {
+		t.Fatal("expected SUCCESS, got", result.Code, result.Reason)
+	}
+	if !result.Signatures[0].Testing {
+		t.Fatal("expected Testing to be true for a t=y key record")
+	}
+}
+
+func TestVerifyRejectsKeyRestrictedToOtherService(t *testing.T) {
+	d, resolver := newTestDKIM(t)
+	resolver["default._domainkey.haiyiyun.com"][0] += "; s=tlsrpt"
+
+	signed, err := d.Sign([]byte(verifySampleEML))
+	if err != nil {
+		t.Fatal("error signing", err)
+	}
+
+	result, err := Verify(signed, resolver)
+	if err != nil {
+		t.Fatal("error verifying", err)
+	}
+	if result.Code != PERMFAIL {
+		t.Fatal("expected PERMFAIL for a key record restricted away from email, got", result.Code)
+	}
+}
+
+func TestVerifyNotSigned(t *testing.T) {
+	result, err := Verify([]byte(verifySampleEML), nil)
+	if err != nil {
+		t.Fatal("error verifying", err)
+	}
+	if result.Code != NOTSIGNED {
+		t.Fatal("expected NOTSIGNED, got", result.Code)
+	}
+}